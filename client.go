@@ -1,6 +1,7 @@
 package sftp
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -16,33 +17,99 @@ type Client struct {
 	writer io.WriteCloser
 
 	incoming chan sshfxp.Packet
-	outgoing chan sshfxp.Packet
+	outgoing chan sshfxp.Message
 	errch    chan error
 	ioErr    error
 
+	// closed is closed once the dispatch goroutine has observed the reader
+	// or writer exiting, waking any request still blocked on a response.
+	closed chan struct{}
+
 	router *Router
 
 	version uint32
 
+	// extensions maps the name of each server-advertised extension (from
+	// the Version packet's extension-pair list) to its version string.
+	extensions map[string]string
+
+	maxConcurrentRequestsPerFile int
+	maxPacket                    int
+	sendBuffer                   int
+
 	wg sync.WaitGroup
 }
 
-func NewClient(r io.ReadCloser, w io.WriteCloser) *Client {
+// ClientOption configures optional behaviour on a Client. Options are applied
+// in order by NewClient, so later options win if they touch the same field.
+type ClientOption func(*Client)
+
+// MaxConcurrentRequestsPerFile sets how many READ/WRITE requests a single
+// File will keep in flight at once. The default is 64.
+func MaxConcurrentRequestsPerFile(n int) ClientOption {
+	return func(cli *Client) {
+		cli.maxConcurrentRequestsPerFile = n
+	}
+}
+
+// MaxPacket sets the largest payload a File will request or send per
+// READ/WRITE packet. The default is 32 KiB; values above maxSFTPPacketPayload
+// are capped, since OpenSSH's sftp-server rejects larger requests once
+// protocol overhead is accounted for.
+func MaxPacket(size int) ClientOption {
+	return func(cli *Client) {
+		if size > maxSFTPPacketPayload {
+			size = maxSFTPPacketPayload
+		}
+		cli.maxPacket = size
+	}
+}
+
+// SendBuffer sets the capacity of the outgoing queue between callers of
+// send/TrySend and the writer goroutine. The default is 0 (unbuffered),
+// which makes every send synchronize with the writer; raising it lets
+// bursts of requests queue up without blocking their caller.
+func SendBuffer(n int) ClientOption {
+	return func(cli *Client) {
+		cli.sendBuffer = n
+	}
+}
+
+// NewClient is a convenience wrapper around NewClientWithContext using
+// context.Background(), for callers that don't need a cancellable handshake.
+func NewClient(r io.ReadCloser, w io.WriteCloser, opts ...ClientOption) *Client {
+	return NewClientWithContext(context.Background(), r, w, opts...)
+}
+
+// NewClientWithContext is like NewClient, but aborts the handshake and
+// returns nil if ctx is done before the server replies. Without this, a
+// server that accepts the connection but never sends its Version packet
+// would make NewClient block forever.
+func NewClientWithContext(ctx context.Context, r io.ReadCloser, w io.WriteCloser, opts ...ClientOption) *Client {
 	cli := &Client{
 		reader:   r,
 		writer:   w,
 		incoming: make(chan sshfxp.Packet),
-		outgoing: make(chan sshfxp.Packet),
 		router:   NewRouter(),
 		errch:    make(chan error, 2), // one error per goroutine
+		closed:   make(chan struct{}),
+
+		maxConcurrentRequestsPerFile: defaultMaxConcurrentRequestsPerFile,
+		maxPacket:                    defaultMaxPacket,
 	}
 
+	for _, opt := range opts {
+		opt(cli)
+	}
+
+	cli.outgoing = make(chan sshfxp.Message, cli.sendBuffer)
+
 	cli.wg.Add(2)
 	go func(cli *Client) {
 		defer cli.wg.Done()
 		defer logrus.Infof("SFTP client writer exited")
 
-		cli.errch <- writeConn(cli.writer, cli.outgoing)
+		cli.errch <- cli.writeLoop()
 	}(cli)
 
 	go func(cli *Client) {
@@ -52,7 +119,7 @@ func NewClient(r io.ReadCloser, w io.WriteCloser) *Client {
 		cli.errch <- readConn(cli.reader, cli.incoming)
 	}(cli)
 
-	if err := cli.DoHandshake(); err != nil {
+	if err := cli.DoHandshake(ctx); err != nil {
 		logrus.Errorf("SFTP handshake failed: %s", err)
 
 		// Close outgoing
@@ -64,6 +131,7 @@ func NewClient(r io.ReadCloser, w io.WriteCloser) *Client {
 		cli.wg.Wait()
 
 		cli.ioErr = err
+		close(cli.closed)
 
 		return nil
 	}
@@ -95,6 +163,7 @@ func NewClient(r io.ReadCloser, w io.WriteCloser) *Client {
 		}
 
 		close(cli.outgoing) // will cause writer to stop if it hasn't already
+		close(cli.closed)   // wake every request still waiting on a response
 
 	}(cli)
 
@@ -106,25 +175,102 @@ func (cli *Client) Wait() {
 	cli.wg.Wait()
 }
 
-func (cli *Client) send(x sshfxp.Message) (<-chan sshfxp.Message, error) {
-	var pkt sshfxp.Packet
-	var res <-chan sshfxp.Message
+// send enqueues x for writing, registering it with the router if it
+// carries an ID. Encoding happens later, in the writer goroutine, so send
+// itself only ever blocks on handing x off. It returns the ID assigned (0
+// if none was needed) and the channel its response will arrive on; pass
+// both to recv to wait for that response under ctx.
+func (cli *Client) send(ctx context.Context, x sshfxp.Message) (uint32, <-chan sshfxp.Message, error) {
+	id, res := cli.registerIfNeeded(x)
+
+	select {
+	case cli.outgoing <- x:
+	case <-ctx.Done():
+		cli.router.Cancel(id)
+		return 0, nil, ctx.Err()
+	case <-cli.closed:
+		return 0, nil, cli.ioErr
+	}
 
-	if header, ok := (interface{}(x)).(sshfxp.Header); ok {
-		id, ch := cli.router.Get()
+	return id, res, nil
+}
 
-		header.SetID(id)
+// TrySend is like send, but never blocks: if the outgoing queue is full it
+// returns ErrBusy immediately instead of waiting for room. Callers that
+// embed SFTP requests into a request-scoped server can use this to enforce
+// their own queue limits instead of piling up goroutines on send.
+func (cli *Client) TrySend(x sshfxp.Message) (uint32, <-chan sshfxp.Message, error) {
+	id, res := cli.registerIfNeeded(x)
+
+	select {
+	case cli.outgoing <- x:
+		return id, res, nil
+	case <-cli.closed:
+		cli.router.Cancel(id)
+		return 0, nil, cli.ioErr
+	default:
+		cli.router.Cancel(id)
+		return 0, nil, ErrBusy
+	}
+}
 
-		res = ch
+func (cli *Client) registerIfNeeded(x sshfxp.Message) (uint32, <-chan sshfxp.Message) {
+	if header, ok := (interface{}(x)).(sshfxp.Header); ok {
+		id, res := cli.router.Get()
+		header.SetID(id)
+		return id, res
 	}
 
-	if err := pkt.Encode(x); err != nil {
-		return nil, err
+	return 0, nil
+}
+
+// recv waits for the response to a request previously submitted via send.
+// If ctx is done first, it cancels the router entry for id (so a late
+// reply is silently dropped) and returns ctx.Err(). If the client's reader
+// or writer goroutine has already exited, it returns cli.ioErr.
+func (cli *Client) recv(ctx context.Context, id uint32, res <-chan sshfxp.Message) (sshfxp.Message, error) {
+	select {
+	case msg := <-res:
+		return msg, nil
+	case <-ctx.Done():
+		cli.router.Cancel(id)
+		return nil, ctx.Err()
+	case <-cli.closed:
+		return nil, cli.ioErr
 	}
+}
 
-	cli.outgoing <- pkt
+// ErrBusy is returned by TrySend when the outgoing queue is full.
+var ErrBusy = errors.New("sftp: outgoing queue is full")
 
-	return res, nil
+// packetPool recycles the sshfxp.Packet buffers used to encode outgoing
+// messages, so a sustained stream of requests doesn't allocate one per
+// call under load.
+var packetPool = sync.Pool{
+	New: func() interface{} { return new(sshfxp.Packet) },
+}
+
+// writeLoop drains cli.outgoing, encoding each message into a pooled
+// sshfxp.Packet right before it hits the wire, and returns once the
+// channel is closed or a write fails.
+func (cli *Client) writeLoop() error {
+	for msg := range cli.outgoing {
+		pkt := packetPool.Get().(*sshfxp.Packet)
+
+		err := pkt.Encode(msg)
+		if err == nil {
+			_, err = pkt.WriteTo(cli.writer)
+		}
+
+		pkt.Reset()
+		packetPool.Put(pkt)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func (cli *Client) handleMessage(msg sshfxp.Packet) error {
@@ -141,16 +287,26 @@ func (cli *Client) handleMessage(msg sshfxp.Packet) error {
 	return nil
 }
 
-func (cli *Client) DoHandshake() error {
+func (cli *Client) DoHandshake(ctx context.Context) error {
 	init := &sshfxp.Init{
 		Version: 3,
 	}
 
-	if _, err := cli.send(init); err != nil {
+	if _, _, err := cli.send(ctx, init); err != nil {
 		return err
 	}
 
-	pkt := <-cli.incoming
+	var pkt sshfxp.Packet
+	select {
+	case pkt = <-cli.incoming:
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-cli.errch:
+		if err == nil {
+			err = errors.New("SFTP connection closed during handshake")
+		}
+		return err
+	}
 
 	msg, err := pkt.Decode()
 	if err != nil {
@@ -165,6 +321,11 @@ func (cli *Client) DoHandshake() error {
 		}
 
 		cli.version = version.Version
+
+		cli.extensions = make(map[string]string, len(version.Extensions))
+		for _, ext := range version.Extensions {
+			cli.extensions[ext.Name] = ext.Data
+		}
 	}
 
 	return nil
@@ -174,20 +335,20 @@ func (cli *Client) Version() uint32 {
 	return cli.version
 }
 
-func (cli *Client) OpenDir(path string) (string, error) {
+func (cli *Client) OpenDir(ctx context.Context, path string) (string, error) {
 	open := &sshfxp.OpenDir{
 		Path: path,
 	}
 
-	var err error
-	var res_chan <-chan sshfxp.Message
-
-	if res_chan, err = cli.send(open); err != nil {
+	id, resCh, err := cli.send(ctx, open)
+	if err != nil {
 		return "", err
 	}
 
-	// wait for result
-	var res interface{} = <-res_chan
+	res, err := cli.recv(ctx, id, resCh)
+	if err != nil {
+		return "", err
+	}
 
 	switch msg := res.(type) {
 	case *sshfxp.Handle:
@@ -199,18 +360,119 @@ func (cli *Client) OpenDir(path string) (string, error) {
 	return "", fmt.Errorf("unexpected response: %#v", res)
 }
 
-func (cli *Client) ReadDir(handle string) ([]*os.FileInfo, error) {
+// CloseDir releases a directory handle returned by OpenDir.
+func (cli *Client) CloseDir(ctx context.Context, handle string) error {
+	id, resCh, err := cli.send(ctx, &sshfxp.Close{Handle: handle})
+	if err != nil {
+		return err
+	}
+
+	res, err := cli.recv(ctx, id, resCh)
+	if err != nil {
+		return err
+	}
+
+	if status, ok := res.(*sshfxp.Status); ok && status.Error != sshfxp.StatusOK {
+		return fmt.Errorf("%d - %s", status.Error, status.Message)
+	}
+
+	return nil
+}
+
+// Open opens path for reading and returns a File positioned at offset 0.
+func (cli *Client) Open(ctx context.Context, path string) (*File, error) {
+	return cli.OpenFile(ctx, path, os.O_RDONLY)
+}
+
+// OpenFile opens path with the given os.O_* flags and returns a File backed
+// by the resulting SFTP handle.
+func (cli *Client) OpenFile(ctx context.Context, path string, flags int) (*File, error) {
+	open := &sshfxp.Open{
+		Path:   path,
+		Pflags: pflagsFromOsFlags(flags),
+	}
+
+	id, resCh, err := cli.send(ctx, open)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := cli.recv(ctx, id, resCh)
+	if err != nil {
+		return nil, err
+	}
+
+	switch msg := res.(type) {
+	case *sshfxp.Handle:
+		return &File{cli: cli, path: path, handle: msg.Handle}, nil
+	case *sshfxp.Status:
+		return nil, fmt.Errorf("%d - %s", msg.Error, msg.Message)
+	default:
+		return nil, fmt.Errorf("unexpected response: %#v", msg)
+	}
+}
+
+// pflagsFromOsFlags translates the os.O_* bits accepted by OpenFile into the
+// sshfxp SSH_FXF_* pflags expected by the SFTP OPEN request.
+func pflagsFromOsFlags(flags int) uint32 {
+	var pflags uint32
+
+	switch {
+	case flags&os.O_RDWR != 0:
+		pflags |= sshfxp.FxfRead | sshfxp.FxfWrite
+	case flags&os.O_WRONLY != 0:
+		pflags |= sshfxp.FxfWrite
+	default:
+		pflags |= sshfxp.FxfRead
+	}
+
+	if flags&os.O_APPEND != 0 {
+		pflags |= sshfxp.FxfAppend
+	}
+	if flags&os.O_CREATE != 0 {
+		pflags |= sshfxp.FxfCreat
+	}
+	if flags&os.O_TRUNC != 0 {
+		pflags |= sshfxp.FxfTrunc
+	}
+	if flags&os.O_EXCL != 0 {
+		pflags |= sshfxp.FxfExcl
+	}
+
+	return pflags
+}
+
+// ReadDir reads the next batch of entries from a directory handle returned
+// by OpenDir. It returns io.EOF once the listing is exhausted; callers
+// should keep calling ReadDir until they see it.
+func (cli *Client) ReadDir(ctx context.Context, handle string) ([]*os.FileInfo, error) {
 	read := &sshfxp.ReadDir{
 		Handle: handle,
 	}
 
-	resCh, err := cli.send(read)
+	id, resCh, err := cli.send(ctx, read)
 	if err != nil {
 		return nil, err
 	}
 
-	res := <-resCh
+	res, err := cli.recv(ctx, id, resCh)
+	if err != nil {
+		return nil, err
+	}
 
-	logrus.Infof("Got: %#v", res)
-	return nil, nil
+	switch msg := res.(type) {
+	case *sshfxp.Name:
+		infos := make([]*os.FileInfo, len(msg.Entries))
+		for i := range msg.Entries {
+			infos[i] = &msg.Entries[i]
+		}
+		return infos, nil
+	case *sshfxp.Status:
+		if msg.Error == sshfxp.StatusEOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("%d - %s", msg.Error, msg.Message)
+	default:
+		return nil, fmt.Errorf("unexpected response: %#v", msg)
+	}
 }
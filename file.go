@@ -0,0 +1,296 @@
+package sftp
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/nethack42/go-sftp/sshfxp"
+)
+
+const (
+	defaultMaxConcurrentRequestsPerFile = 64
+	defaultMaxPacket                    = 32 * 1024
+
+	// maxSFTPPacketPayload is the largest READ/WRITE payload OpenSSH's
+	// sftp-server will accept once sshfxp framing and header overhead are
+	// taken into account (256 KiB total packet size).
+	maxSFTPPacketPayload = 256*1024 - 1024
+)
+
+// File is a handle to an open remote file, returned by Client.Open and
+// Client.OpenFile. It implements io.ReaderAt and io.WriterAt by splitting
+// transfers into maxPacket-sized sshfxp READ/WRITE requests and keeping up
+// to MaxConcurrentRequestsPerFile of them in flight at once.
+type File struct {
+	cli    *Client
+	path   string
+	handle string
+}
+
+// Close releases the remote file handle.
+func (f *File) Close() error {
+	return f.CloseContext(context.Background())
+}
+
+// CloseContext is like Close, but aborts if ctx is done first.
+func (f *File) CloseContext(ctx context.Context) error {
+	id, resCh, err := f.cli.send(ctx, &sshfxp.Close{Handle: f.handle})
+	if err != nil {
+		return err
+	}
+
+	res, err := f.cli.recv(ctx, id, resCh)
+	if err != nil {
+		return err
+	}
+
+	if status, ok := res.(*sshfxp.Status); ok && status.Error != sshfxp.StatusOK {
+		return fmt.Errorf("%d - %s", status.Error, status.Message)
+	}
+
+	return nil
+}
+
+// readWindow is one in-flight READ or WRITE request, tracked by the offset
+// within the caller's buffer that its response belongs at.
+type readWindow struct {
+	bufOffset int
+	reqLen    int
+	id        uint32
+	resCh     <-chan sshfxp.Message
+}
+
+// cancelPending releases the Router entry for every window in pending whose
+// request was already sent but whose response will never be waited for,
+// because ReadAtContext/WriteAtContext is returning early. Without this,
+// each concurrent window still outstanding when a transfer is cancelled or
+// fails would leak its id in the Router forever.
+func (f *File) cancelPending(pending []readWindow) {
+	for _, win := range pending {
+		f.cli.router.Cancel(win.id)
+	}
+}
+
+// ReadAt implements io.ReaderAt using context.Background(); use
+// ReadAtContext to make a transfer cancellable.
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	return f.ReadAtContext(context.Background(), p, off)
+}
+
+// ReadAtContext issues up to MaxConcurrentRequestsPerFile READ requests at
+// once, each carrying at most MaxPacket bytes, and reassembles the replies
+// into p in request order. It returns early with ctx.Err() if ctx is done
+// before all requests complete.
+func (f *File) ReadAtContext(ctx context.Context, p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	maxPacket := f.cli.maxPacket
+	maxConcurrent := f.cli.maxConcurrentRequestsPerFile
+
+	var (
+		sent    int
+		total   int
+		pending []readWindow
+		readErr error
+	)
+
+	for sent < len(p) || len(pending) > 0 {
+		for len(pending) < maxConcurrent && sent < len(p) && readErr == nil {
+			n := maxPacket
+			if remaining := len(p) - sent; remaining < n {
+				n = remaining
+			}
+
+			id, resCh, err := f.cli.send(ctx, &sshfxp.Read{
+				Handle: f.handle,
+				Offset: uint64(off) + uint64(sent),
+				Len:    uint32(n),
+			})
+			if err != nil {
+				f.cancelPending(pending)
+				return total, err
+			}
+
+			pending = append(pending, readWindow{bufOffset: sent, reqLen: n, id: id, resCh: resCh})
+			sent += n
+		}
+
+		if len(pending) == 0 {
+			break
+		}
+
+		win := pending[0]
+		pending = pending[1:]
+
+		msg, err := f.cli.recv(ctx, win.id, win.resCh)
+		if err != nil {
+			f.cancelPending(pending)
+			return total, err
+		}
+
+		switch msg := msg.(type) {
+		case *sshfxp.Data:
+			n := copy(p[win.bufOffset:], msg.Data)
+			total += n
+			if n < win.reqLen {
+				// A short Data reply does not mean EOF per the spec - only
+				// an explicit SSH_FX_EOF status does. A compliant server
+				// may legitimately return fewer bytes than requested, so
+				// re-request the remainder of this window instead of
+				// leaving a gap of unfilled bytes in p.
+				id, resCh, err := f.cli.send(ctx, &sshfxp.Read{
+					Handle: f.handle,
+					Offset: uint64(off) + uint64(win.bufOffset) + uint64(n),
+					Len:    uint32(win.reqLen - n),
+				})
+				if err != nil {
+					f.cancelPending(pending)
+					return total, err
+				}
+
+				rest := readWindow{bufOffset: win.bufOffset + n, reqLen: win.reqLen - n, id: id, resCh: resCh}
+				pending = append([]readWindow{rest}, pending...)
+			}
+		case *sshfxp.Status:
+			if readErr == nil {
+				if msg.Error == sshfxp.StatusEOF {
+					readErr = io.EOF
+				} else {
+					readErr = fmt.Errorf("%d - %s", msg.Error, msg.Message)
+				}
+			}
+		default:
+			if readErr == nil {
+				readErr = fmt.Errorf("unexpected response: %#v", msg)
+			}
+		}
+	}
+
+	return total, readErr
+}
+
+// WriteAt implements io.WriterAt using context.Background(); use
+// WriteAtContext to make a transfer cancellable.
+func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	return f.WriteAtContext(context.Background(), p, off)
+}
+
+// WriteAtContext issues up to MaxConcurrentRequestsPerFile WRITE requests
+// at once, each carrying at most MaxPacket bytes. It returns early with
+// ctx.Err() if ctx is done before all requests complete.
+func (f *File) WriteAtContext(ctx context.Context, p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	maxPacket := f.cli.maxPacket
+	maxConcurrent := f.cli.maxConcurrentRequestsPerFile
+
+	var (
+		sent     int
+		total    int
+		pending  []readWindow
+		writeErr error
+	)
+
+	for sent < len(p) || len(pending) > 0 {
+		for len(pending) < maxConcurrent && sent < len(p) && writeErr == nil {
+			n := maxPacket
+			if remaining := len(p) - sent; remaining < n {
+				n = remaining
+			}
+
+			id, resCh, err := f.cli.send(ctx, &sshfxp.Write{
+				Handle: f.handle,
+				Offset: uint64(off) + uint64(sent),
+				Data:   p[sent : sent+n],
+			})
+			if err != nil {
+				f.cancelPending(pending)
+				return total, err
+			}
+
+			pending = append(pending, readWindow{bufOffset: sent, reqLen: n, id: id, resCh: resCh})
+			sent += n
+		}
+
+		if len(pending) == 0 {
+			break
+		}
+
+		win := pending[0]
+		pending = pending[1:]
+
+		msg, err := f.cli.recv(ctx, win.id, win.resCh)
+		if err != nil {
+			f.cancelPending(pending)
+			return total, err
+		}
+
+		if status, ok := msg.(*sshfxp.Status); ok && status.Error != sshfxp.StatusOK {
+			writeErr = fmt.Errorf("%d - %s", status.Error, status.Message)
+			continue
+		}
+
+		total += win.reqLen
+	}
+
+	return total, writeErr
+}
+
+// WriteTo implements io.WriterTo, streaming the remote file into w using
+// ReadAt under the hood.
+func (f *File) WriteTo(w io.Writer) (int64, error) {
+	buf := make([]byte, f.cli.maxPacket)
+
+	var total int64
+	var off int64
+
+	for {
+		n, err := f.ReadAt(buf, off)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return total, werr
+			}
+			total += int64(n)
+			off += int64(n)
+		}
+
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// ReadFrom implements io.ReaderFrom, streaming r into the remote file using
+// WriteAt under the hood.
+func (f *File) ReadFrom(r io.Reader) (int64, error) {
+	buf := make([]byte, f.cli.maxPacket)
+
+	var total int64
+	var off int64
+
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, werr := f.WriteAt(buf[:n], off); werr != nil {
+				return total, werr
+			}
+			total += int64(n)
+			off += int64(n)
+		}
+
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestFileWindowedReadWriteAt(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+
+	srv := NewServer(serverConn, InMemHandler())
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve() }()
+
+	// A small MaxPacket and a handful of concurrent requests forces the
+	// payload below to be split across many windowed READ/WRITE packets
+	// instead of a single round trip.
+	cli := NewClient(clientConn, clientConn, MaxPacket(1024), MaxConcurrentRequestsPerFile(4))
+	if cli == nil {
+		t.Fatal("handshake with in-memory server failed")
+	}
+	defer func() {
+		cli.Wait()
+		<-done
+	}()
+
+	ctx := context.Background()
+	want := bytes.Repeat([]byte("0123456789abcdef"), 1024) // 16 KiB
+
+	wf, err := cli.OpenFile(ctx, "/windowed.bin", os.O_WRONLY|os.O_CREATE)
+	if err != nil {
+		t.Fatalf("OpenFile (write): %s", err)
+	}
+	if _, err := wf.WriteAtContext(ctx, want, 0); err != nil {
+		t.Fatalf("WriteAt: %s", err)
+	}
+	if err := wf.CloseContext(ctx); err != nil {
+		t.Fatalf("Close (write): %s", err)
+	}
+
+	rf, err := cli.Open(ctx, "/windowed.bin")
+	if err != nil {
+		t.Fatalf("Open (read): %s", err)
+	}
+	defer rf.CloseContext(ctx)
+
+	var got bytes.Buffer
+	if _, err := rf.WriteTo(&got); err != nil {
+		t.Fatalf("WriteTo: %s", err)
+	}
+
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Fatalf("got %d bytes, want %d bytes; content mismatch", got.Len(), len(want))
+	}
+
+	// WriteTo reads through a maxPacket-sized buffer, so it never keeps more
+	// than one READ in flight. Read the whole file through ReadAtContext
+	// directly to exercise the concurrent windowed read path too.
+	gotAt := make([]byte, len(want))
+	if _, err := rf.ReadAtContext(ctx, gotAt, 0); err != nil {
+		t.Fatalf("ReadAtContext: %s", err)
+	}
+	if !bytes.Equal(gotAt, want) {
+		t.Fatalf("ReadAtContext: got %d bytes, want %d bytes; content mismatch", len(gotAt), len(want))
+	}
+}
+
+// shortReaderHandler serves every Fileread as a shortReaderAt over data, to
+// exercise a server that returns fewer bytes than requested without that
+// meaning EOF.
+type shortReaderHandler struct{ data []byte }
+
+func (h shortReaderHandler) Fileread(*Request) (io.ReaderAt, error) {
+	return shortReaderAt{data: h.data}, nil
+}
+
+// shortReaderAt never returns more than 4 bytes per call, and never signals
+// io.EOF until off is at or past the end of data - a compliant but stingy
+// server.
+type shortReaderAt struct{ data []byte }
+
+func (r shortReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(r.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, r.data[off:])
+	if n > 4 {
+		n = 4
+	}
+	return n, nil
+}
+
+func TestFileReadAtContextRetriesShortNonEOFReply(t *testing.T) {
+	want := bytes.Repeat([]byte("0123456789abcdef"), 64) // 1 KiB
+
+	clientConn, serverConn := net.Pipe()
+
+	srv := NewServer(serverConn, Handlers{FileGet: shortReaderHandler{data: want}})
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve() }()
+
+	cli := NewClient(clientConn, clientConn, MaxConcurrentRequestsPerFile(4))
+	if cli == nil {
+		t.Fatal("handshake with in-memory server failed")
+	}
+	defer func() {
+		cli.Wait()
+		<-done
+	}()
+
+	ctx := context.Background()
+
+	rf, err := cli.Open(ctx, "/short.bin")
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer rf.CloseContext(ctx)
+
+	got := make([]byte, len(want))
+	if _, err := rf.ReadAtContext(ctx, got, 0); err != nil {
+		t.Fatalf("ReadAtContext: %s", err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Fatalf("ReadAtContext: got %d bytes, want %d bytes; content mismatch", len(got), len(want))
+	}
+}
@@ -0,0 +1,45 @@
+package sftp
+
+import (
+	"context"
+	"fmt"
+	"path"
+)
+
+// Glob returns the paths under the remote filesystem root matching
+// pattern, using the same syntax as path.Match.
+//
+// Unlike filepath.Glob, this walks the entire tree from "/" looking for
+// matches rather than short-circuiting on the pattern's literal prefix,
+// since the client has no way to Stat a single path directly yet. It is
+// only suitable for filesystems small enough to walk in full.
+func (cli *Client) Glob(pattern string) ([]string, error) {
+	return cli.GlobContext(context.Background(), pattern)
+}
+
+// GlobContext is like Glob, but aborts the walk with ctx.Err() if ctx is
+// done first.
+func (cli *Client) GlobContext(ctx context.Context, pattern string) ([]string, error) {
+	if !path.IsAbs(pattern) {
+		return nil, fmt.Errorf("sftp: Glob pattern must be absolute, got %q", pattern)
+	}
+
+	var matches []string
+
+	w := cli.WalkContext(ctx, "/")
+	for w.Step() {
+		if err := w.Err(); err != nil {
+			return matches, err
+		}
+
+		ok, err := path.Match(pattern, w.Path())
+		if err != nil {
+			return matches, err
+		}
+		if ok {
+			matches = append(matches, w.Path())
+		}
+	}
+
+	return matches, nil
+}
@@ -0,0 +1,51 @@
+package sftp
+
+import (
+	"io"
+	"os"
+)
+
+// Handlers bundles the four interfaces a server-side backend implements.
+// Any of the four may be nil, in which case requests that would dispatch to
+// it fail with sshfxp.StatusOpUnsupported.
+type Handlers struct {
+	FileGet  FileReader
+	FilePut  FileWriter
+	FileCmd  FileCmder
+	FileList FileLister
+}
+
+// FileReader is implemented by backends that serve file contents for READ
+// requests.
+type FileReader interface {
+	Fileread(*Request) (io.ReaderAt, error)
+}
+
+// FileWriter is implemented by backends that accept file contents from
+// WRITE requests.
+type FileWriter interface {
+	Filewrite(*Request) (io.WriterAt, error)
+}
+
+// FileCmder is implemented by backends that handle the non-I/O requests:
+// SETSTAT, RENAME, REMOVE, MKDIR, RMDIR and SYMLINK. The Request's Method
+// field identifies which of these is being requested.
+type FileCmder interface {
+	Filecmd(*Request) error
+}
+
+// FileLister is implemented by backends that serve OPENDIR/READDIR as well
+// as STAT/LSTAT/REALPATH requests. The Request's Method field distinguishes
+// a directory listing from a single-entry stat.
+type FileLister interface {
+	Filelist(*Request) (ListerAt, error)
+}
+
+// ListerAt pages through a directory listing, mirroring io.ReaderAt but for
+// os.FileInfo entries instead of bytes. Implementations should behave like
+// io.ReaderAt: ListAt may be called concurrently and with out-of-order
+// offsets, and should return io.EOF once offset reaches the end of the
+// listing.
+type ListerAt interface {
+	ListAt([]os.FileInfo, int64) (int, error)
+}
@@ -0,0 +1,196 @@
+package sftp
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// InMemHandler returns a Handlers backed by a single in-memory filesystem
+// rooted at "/". It exists to exercise Server without a real filesystem
+// underneath, and as a minimal reference for implementing the four
+// Handlers interfaces.
+func InMemHandler() Handlers {
+	root := &memFile{name: "/", isDir: true, modTime: time.Time{}}
+	fs := &memFS{files: map[string]*memFile{"/": root}}
+
+	return Handlers{
+		FileGet:  fs,
+		FilePut:  fs,
+		FileCmd:  fs,
+		FileList: fs,
+	}
+}
+
+type memFile struct {
+	name    string
+	isDir   bool
+	modTime time.Time
+
+	mu   sync.Mutex
+	data []byte
+}
+
+func (f *memFile) Name() string { return path.Base(f.name) }
+
+func (f *memFile) Size() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return int64(len(f.data))
+}
+
+func (f *memFile) Mode() os.FileMode {
+	if f.isDir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+func (f *memFile) ModTime() time.Time { return f.modTime }
+func (f *memFile) IsDir() bool        { return f.isDir }
+func (f *memFile) Sys() interface{}   { return nil }
+
+// memReaderAt and memWriterAt adapt a memFile's byte slice to io.ReaderAt
+// and io.WriterAt, growing the backing slice on write as needed.
+type memReaderAt struct{ f *memFile }
+
+func (r memReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.f.mu.Lock()
+	defer r.f.mu.Unlock()
+
+	if off >= int64(len(r.f.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, r.f.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+type memWriterAt struct{ f *memFile }
+
+func (w memWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	w.f.mu.Lock()
+	defer w.f.mu.Unlock()
+
+	end := off + int64(len(p))
+	if end > int64(len(w.f.data)) {
+		grown := make([]byte, end)
+		copy(grown, w.f.data)
+		w.f.data = grown
+	}
+
+	return copy(w.f.data[off:end], p), nil
+}
+
+type memFS struct {
+	mu    sync.Mutex
+	files map[string]*memFile
+}
+
+func (fs *memFS) Fileread(r *Request) (io.ReaderAt, error) {
+	fs.mu.Lock()
+	f, ok := fs.files[r.Filepath]
+	fs.mu.Unlock()
+	if !ok || f.isDir {
+		return nil, os.ErrNotExist
+	}
+	return memReaderAt{f}, nil
+}
+
+func (fs *memFS) Filewrite(r *Request) (io.WriterAt, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	f, ok := fs.files[r.Filepath]
+	if !ok {
+		f = &memFile{name: r.Filepath, modTime: time.Time{}}
+		fs.files[r.Filepath] = f
+	}
+	return memWriterAt{f}, nil
+}
+
+func (fs *memFS) Filecmd(r *Request) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	switch r.Method {
+	case MethodMkdir:
+		fs.files[r.Filepath] = &memFile{name: r.Filepath, isDir: true, modTime: time.Time{}}
+		return nil
+	case MethodRmdir, MethodRemove:
+		if _, ok := fs.files[r.Filepath]; !ok {
+			return os.ErrNotExist
+		}
+		delete(fs.files, r.Filepath)
+		return nil
+	case MethodRename:
+		f, ok := fs.files[r.Filepath]
+		if !ok {
+			return os.ErrNotExist
+		}
+		delete(fs.files, r.Filepath)
+		f.name = r.Target
+		fs.files[r.Target] = f
+		return nil
+	case MethodSetstat:
+		if _, ok := fs.files[r.Filepath]; !ok {
+			return os.ErrNotExist
+		}
+		return nil
+	case MethodSymlink:
+		return fmt.Errorf("symlinks are not supported by the in-memory backend")
+	default:
+		return fmt.Errorf("unsupported command: %s", r.Method)
+	}
+}
+
+func (fs *memFS) Filelist(r *Request) (ListerAt, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	switch r.Method {
+	case MethodOpenDir:
+		var entries []os.FileInfo
+		for p, f := range fs.files {
+			if p == r.Filepath || path.Dir(p) != path.Clean(r.Filepath) {
+				continue
+			}
+			entries = append(entries, f)
+		}
+		return &sliceLister{entries: entries}, nil
+
+	case MethodStat, MethodLstat, MethodRealpath:
+		f, ok := fs.files[r.Filepath]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return &sliceLister{entries: []os.FileInfo{f}}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported list method: %s", r.Method)
+	}
+}
+
+// sliceLister implements ListerAt over a fixed, in-memory slice of entries.
+type sliceLister struct {
+	entries []os.FileInfo
+}
+
+func (l *sliceLister) ListAt(dst []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l.entries)) {
+		return 0, io.EOF
+	}
+
+	n := copy(dst, l.entries[offset:])
+	if offset+int64(n) >= int64(len(l.entries)) {
+		return n, io.EOF
+	}
+	return n, nil
+}
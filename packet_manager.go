@@ -0,0 +1,84 @@
+package sftp
+
+import (
+	"sync"
+
+	"github.com/nethack42/go-sftp/sshfxp"
+)
+
+// packetManager lets a worker pool process requests out of order while
+// guaranteeing responses hit the wire in the same order the requests
+// arrived in, as required by section 7 of the draft ("responses to
+// non-overlapping requests... may be sent in any order, but a server
+// SHOULD send responses in the same order the corresponding requests were
+// received").
+//
+// Each incoming packet is assigned a monotonically increasing sequence
+// number as it is read off the wire. Workers call readyToSend with that
+// same sequence number once a response packet is ready; readyToSend holds
+// the packet until every earlier sequence number has already been flushed,
+// then drains as much of the contiguous prefix as it can.
+type packetManager struct {
+	mu      sync.Mutex
+	nextIn  uint32
+	nextOut uint32
+	pending map[uint32]*sshfxp.Packet
+
+	outgoing chan<- sshfxp.Packet
+	done     chan struct{}
+}
+
+func newPacketManager(outgoing chan<- sshfxp.Packet) *packetManager {
+	return &packetManager{
+		pending:  make(map[uint32]*sshfxp.Packet),
+		outgoing: outgoing,
+		done:     make(chan struct{}),
+	}
+}
+
+// stop aborts any readyToSend call currently blocked sending to outgoing.
+// Call it once the connection is going down (outgoing's reader has exited
+// or is about to), so workers still holding a response don't block forever
+// on a channel nobody drains, which would otherwise leak the whole worker
+// pool and keep Serve from returning.
+func (pm *packetManager) stop() {
+	close(pm.done)
+}
+
+// nextSeq returns the next sequence number to assign to an incoming packet.
+// Call once per packet read, in read order.
+func (pm *packetManager) nextSeq() uint32 {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	seq := pm.nextIn
+	pm.nextIn++
+	return seq
+}
+
+// readyToSend marks the response for seq as ready and flushes it, along
+// with any immediately-following responses that are also ready, to the
+// outgoing channel in order.
+func (pm *packetManager) readyToSend(seq uint32, pkt sshfxp.Packet) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	pm.pending[seq] = &pkt
+
+	for {
+		next, ok := pm.pending[pm.nextOut]
+		if !ok {
+			break
+		}
+
+		delete(pm.pending, pm.nextOut)
+
+		select {
+		case pm.outgoing <- *next:
+		case <-pm.done:
+			return
+		}
+
+		pm.nextOut++
+	}
+}
@@ -0,0 +1,33 @@
+package sftp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nethack42/go-sftp/sshfxp"
+)
+
+// TestPacketManagerStopUnblocksPendingSend ensures a worker stuck in
+// readyToSend because nobody is draining outgoing (e.g. the writer
+// goroutine died) is released once stop is called, instead of blocking
+// forever and leaking the whole worker pool.
+func TestPacketManagerStopUnblocksPendingSend(t *testing.T) {
+	outgoing := make(chan sshfxp.Packet) // unbuffered, never drained
+
+	pm := newPacketManager(outgoing)
+	seq := pm.nextSeq()
+
+	done := make(chan struct{})
+	go func() {
+		pm.readyToSend(seq, sshfxp.Packet{})
+		close(done)
+	}()
+
+	pm.stop()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("readyToSend still blocked after stop")
+	}
+}
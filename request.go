@@ -0,0 +1,46 @@
+package sftp
+
+import "github.com/nethack42/go-sftp/sshfxp"
+
+// Request methods, one per sshfxp request type a Server may dispatch to a
+// Handlers backend.
+const (
+	MethodOpen     = "Open"
+	MethodClose    = "Close"
+	MethodRead     = "Read"
+	MethodWrite    = "Write"
+	MethodSetstat  = "Setstat"
+	MethodRename   = "Rename"
+	MethodRemove   = "Remove"
+	MethodMkdir    = "Mkdir"
+	MethodRmdir    = "Rmdir"
+	MethodSymlink  = "Symlink"
+	MethodOpenDir  = "List"
+	MethodStat     = "Stat"
+	MethodLstat    = "Lstat"
+	MethodReadlink = "Readlink"
+	MethodRealpath = "Realpath"
+)
+
+// Request describes a single incoming sshfxp request, translated into a
+// form Handlers implementations deal with instead of raw sshfxp packets.
+type Request struct {
+	// Method identifies which Handlers interface method this request
+	// dispatches to, and, for FileCmder/FileLister, which operation within
+	// that interface is being requested.
+	Method string
+
+	// Filepath is the path the request operates on.
+	Filepath string
+
+	// Target is the link target for MethodSymlink, or the destination path
+	// for MethodRename.
+	Target string
+
+	// Flags carries the SSH_FXF_* pflags of an Open request.
+	Flags uint32
+
+	// Attrs carries the attributes of a Setstat or the requested attributes
+	// of an Open/Mkdir request.
+	Attrs sshfxp.Attr
+}
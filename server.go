@@ -0,0 +1,200 @@
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/nethack42/go-sftp/sshfxp"
+)
+
+const defaultWorkerPoolSize = 8
+
+// ServerOption configures optional behaviour on a Server.
+type ServerOption func(*Server)
+
+// WorkerPoolSize sets how many requests a Server processes concurrently.
+// The default is 8.
+func WorkerPoolSize(n int) ServerOption {
+	return func(srv *Server) {
+		srv.workers = n
+	}
+}
+
+// Server implements the SFTP subsystem on top of a Handlers backend. It
+// decodes incoming sshfxp requests, dispatches them to the matching
+// FileReader/FileWriter/FileCmder/FileLister method, and writes the
+// responses back out in the order the requests were received, even though
+// they may be processed out of order by the worker pool.
+//
+// A Server is meant to be handed to an ssh.Server's SubsystemHandler for
+// the "sftp" subsystem, with rwc set to the ssh.Channel of the incoming
+// session.
+type Server struct {
+	rwc io.ReadWriteCloser
+
+	handlers Handlers
+
+	workers int
+
+	incoming chan sshfxp.Packet
+	outgoing chan sshfxp.Packet
+
+	openHandles *handleTable
+
+	wg sync.WaitGroup
+}
+
+// NewServer returns a Server that dispatches requests read from rwc to h.
+func NewServer(rwc io.ReadWriteCloser, h Handlers, opts ...ServerOption) *Server {
+	srv := &Server{
+		rwc:      rwc,
+		handlers: h,
+		workers:  defaultWorkerPoolSize,
+
+		incoming: make(chan sshfxp.Packet),
+		outgoing: make(chan sshfxp.Packet),
+
+		openHandles: newHandleTable(),
+	}
+
+	for _, opt := range opts {
+		opt(srv)
+	}
+
+	return srv
+}
+
+// Serve runs the subsystem until rwc is closed or a fatal I/O error occurs.
+// It blocks until every request already handed to the worker pool has
+// finished; responses that have not reached the writer by the time the
+// connection goes down are dropped.
+func (srv *Server) Serve() error {
+	errch := make(chan error, 2)
+
+	srv.wg.Add(1)
+	go func() {
+		defer srv.wg.Done()
+		errch <- writeConn(srv.rwc, srv.outgoing)
+	}()
+
+	readErrCh := make(chan error, 1)
+	go func() {
+		readErrCh <- readConn(srv.rwc, srv.incoming)
+	}()
+
+	if err := srv.handshake(); err != nil {
+		close(srv.outgoing)
+		srv.wg.Wait()
+		return err
+	}
+
+	pktMgr := newPacketManager(srv.outgoing)
+	sem := make(chan struct{}, srv.workers)
+
+	var inflight sync.WaitGroup
+	var serveErr error
+
+L:
+	for {
+		select {
+		case pkt, ok := <-srv.incoming:
+			if !ok {
+				break L
+			}
+
+			seq := pktMgr.nextSeq()
+
+			inflight.Add(1)
+			sem <- struct{}{}
+			go func(pkt sshfxp.Packet, seq uint32) {
+				defer inflight.Done()
+				defer func() { <-sem }()
+
+				pktMgr.readyToSend(seq, srv.safeDispatch(pkt))
+			}(pkt, seq)
+
+		case err := <-readErrCh:
+			if err != nil {
+				serveErr = err
+				logrus.Errorf("SFTP server reader exited: %s", err)
+			} else {
+				logrus.Infof("SFTP server reader exited")
+			}
+			break L
+
+		case err := <-errch:
+			if err != nil {
+				serveErr = err
+				logrus.Errorf("SFTP server writer exited: %s", err)
+			} else {
+				logrus.Infof("SFTP server writer exited")
+			}
+			break L
+		}
+	}
+
+	// Unblock any worker stuck in pktMgr.readyToSend before waiting on
+	// them: once the loop above has broken, nothing guarantees srv.outgoing
+	// is still being drained (the writer may be the thing that just died),
+	// so a pending send would otherwise hang forever and leak the pool.
+	pktMgr.stop()
+	inflight.Wait()
+	close(srv.outgoing)
+	srv.wg.Wait()
+
+	return serveErr
+}
+
+// safeDispatch decodes pkt and dispatches it with a recover in place, so a
+// panic inside a user-supplied Handlers implementation turns into a
+// StatusFailure reply for that one request instead of taking down the
+// whole server and every other session it's handling.
+//
+// The request id is decoded before the recoverable section starts, so a
+// panic from deep inside a Handlers method still produces a Status reply
+// carrying the caller's real id instead of 0 - the client registered that
+// id with its Router and is blocked waiting on it, potentially forever.
+func (srv *Server) safeDispatch(pkt sshfxp.Packet) sshfxp.Packet {
+	id, msg, errPkt, ok := srv.decode(pkt)
+	if !ok {
+		return errPkt
+	}
+
+	return srv.safeDispatchMsg(id, msg)
+}
+
+func (srv *Server) safeDispatchMsg(id uint32, msg sshfxp.Message) (resp sshfxp.Packet) {
+	defer func() {
+		if r := recover(); r != nil {
+			logrus.Errorf("panic handling request: %v", r)
+			resp = statusPacket(id, sshfxp.StatusFailure, fmt.Sprintf("internal error: %v", r))
+		}
+	}()
+
+	return srv.dispatchMsg(id, msg)
+}
+
+func (srv *Server) handshake() error {
+	pkt := <-srv.incoming
+
+	msg, err := pkt.Decode()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := msg.(*sshfxp.Init); !ok {
+		return errors.New("unexpected message received")
+	}
+
+	var reply sshfxp.Packet
+	if err := reply.Encode(&sshfxp.Version{Version: 3}); err != nil {
+		return err
+	}
+
+	srv.outgoing <- reply
+
+	return nil
+}
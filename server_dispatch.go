@@ -0,0 +1,326 @@
+package sftp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/nethack42/go-sftp/sshfxp"
+)
+
+// handleTable hands out opaque SFTP handle strings and maps them back to
+// either the io.ReaderAt/io.WriterAt returned by FileReader/FileWriter (for
+// OPEN handles) or a ListerAt cursor (for OPENDIR handles).
+type handleTable struct {
+	mu       sync.Mutex
+	byHandle map[string]interface{}
+}
+
+func newHandleTable() *handleTable {
+	return &handleTable{byHandle: make(map[string]interface{})}
+}
+
+func (t *handleTable) new(v interface{}) string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	handle := hex.EncodeToString(buf)
+
+	t.mu.Lock()
+	t.byHandle[handle] = v
+	t.mu.Unlock()
+
+	return handle
+}
+
+func (t *handleTable) get(handle string) (interface{}, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	v, ok := t.byHandle[handle]
+	return v, ok
+}
+
+func (t *handleTable) close(handle string) {
+	t.mu.Lock()
+	delete(t.byHandle, handle)
+	t.mu.Unlock()
+}
+
+// statusPacket encodes an sshfxp.Status reply with the given id.
+func statusPacket(id uint32, code uint32, msg string) sshfxp.Packet {
+	var pkt sshfxp.Packet
+	pkt.Encode(&sshfxp.Status{ID: id, Error: code, Message: msg})
+	return pkt
+}
+
+func errStatusPacket(id uint32, err error) sshfxp.Packet {
+	return statusPacket(id, sshfxp.StatusFailure, err.Error())
+}
+
+// decode unwraps an incoming packet into its id and sshfxp.Message. It
+// never itself panics, so callers can safely read the id before entering
+// a recoverable section that dispatches the message to user-supplied
+// Handlers code. ok is false if decoding failed, in which case errPkt is
+// the Status reply to send back (with id 0, since no id could be read).
+func (srv *Server) decode(pkt sshfxp.Packet) (id uint32, msg sshfxp.Message, errPkt sshfxp.Packet, ok bool) {
+	m, err := pkt.Decode()
+	if err != nil {
+		return 0, nil, statusPacket(0, sshfxp.StatusBadMessage, err.Error()), false
+	}
+
+	header, ok := m.(sshfxp.Header)
+	if !ok {
+		return 0, nil, statusPacket(0, sshfxp.StatusBadMessage, "request carries no id"), false
+	}
+
+	return header.GetID(), m, sshfxp.Packet{}, true
+}
+
+// dispatchMsg routes an already-decoded message to the matching Handlers
+// method. It may panic if the Handlers implementation does; safeDispatch
+// recovers from that using the id decode already extracted.
+func (srv *Server) dispatchMsg(id uint32, msg sshfxp.Message) sshfxp.Packet {
+	switch m := msg.(type) {
+	case *sshfxp.Open:
+		return srv.handleOpen(id, m)
+	case *sshfxp.Close:
+		srv.openHandles.close(m.Handle)
+		return statusPacket(id, sshfxp.StatusOK, "")
+	case *sshfxp.Read:
+		return srv.handleRead(id, m)
+	case *sshfxp.Write:
+		return srv.handleWrite(id, m)
+	case *sshfxp.OpenDir:
+		return srv.handleOpenDir(id, m)
+	case *sshfxp.ReadDir:
+		return srv.handleReadDir(id, m)
+	case *sshfxp.Remove:
+		return srv.handleCmd(id, &Request{Method: MethodRemove, Filepath: m.Path})
+	case *sshfxp.Mkdir:
+		return srv.handleCmd(id, &Request{Method: MethodMkdir, Filepath: m.Path, Attrs: m.Attrs})
+	case *sshfxp.Rmdir:
+		return srv.handleCmd(id, &Request{Method: MethodRmdir, Filepath: m.Path})
+	case *sshfxp.Rename:
+		return srv.handleCmd(id, &Request{Method: MethodRename, Filepath: m.OldPath, Target: m.NewPath})
+	case *sshfxp.Symlink:
+		return srv.handleCmd(id, &Request{Method: MethodSymlink, Filepath: m.LinkPath, Target: m.TargetPath})
+	case *sshfxp.SetStat:
+		return srv.handleCmd(id, &Request{Method: MethodSetstat, Filepath: m.Path, Attrs: m.Attrs})
+	case *sshfxp.Stat:
+		return srv.handleStat(id, MethodStat, m.Path)
+	case *sshfxp.LStat:
+		return srv.handleStat(id, MethodLstat, m.Path)
+	case *sshfxp.Realpath:
+		return srv.handleStat(id, MethodRealpath, m.Path)
+	default:
+		return statusPacket(id, sshfxp.StatusOpUnsupported, "unsupported request")
+	}
+}
+
+// readWriterAt combines an io.ReaderAt and an io.WriterAt into a single
+// handle-table entry for files opened with both SSH_FXF_READ and
+// SSH_FXF_WRITE set, so handleRead and handleWrite both succeed against
+// the same handle.
+type readWriterAt struct {
+	io.ReaderAt
+	io.WriterAt
+}
+
+func (srv *Server) handleOpen(id uint32, m *sshfxp.Open) sshfxp.Packet {
+	req := &Request{Method: MethodOpen, Filepath: m.Path, Flags: m.Pflags, Attrs: m.Attrs}
+
+	wantWrite := m.Pflags&sshfxp.FxfWrite != 0
+	wantRead := m.Pflags&sshfxp.FxfRead != 0 || !wantWrite
+
+	var r io.ReaderAt
+	var w io.WriterAt
+
+	if wantWrite {
+		if srv.handlers.FilePut == nil {
+			return statusPacket(id, sshfxp.StatusOpUnsupported, "writes not supported")
+		}
+
+		var err error
+		w, err = srv.handlers.FilePut.Filewrite(req)
+		if err != nil {
+			return errStatusPacket(id, err)
+		}
+	}
+
+	if wantRead {
+		if srv.handlers.FileGet == nil {
+			return statusPacket(id, sshfxp.StatusOpUnsupported, "reads not supported")
+		}
+
+		var err error
+		r, err = srv.handlers.FileGet.Fileread(req)
+		if err != nil {
+			return errStatusPacket(id, err)
+		}
+	}
+
+	var v interface{}
+	switch {
+	case r != nil && w != nil:
+		v = readWriterAt{ReaderAt: r, WriterAt: w}
+	case w != nil:
+		v = w
+	default:
+		v = r
+	}
+
+	handle := srv.openHandles.new(v)
+	var pkt sshfxp.Packet
+	pkt.Encode(&sshfxp.Handle{ID: id, Handle: handle})
+	return pkt
+}
+
+func (srv *Server) handleRead(id uint32, m *sshfxp.Read) sshfxp.Packet {
+	v, ok := srv.openHandles.get(m.Handle)
+	if !ok {
+		return statusPacket(id, sshfxp.StatusFailure, "invalid handle")
+	}
+
+	r, ok := v.(io.ReaderAt)
+	if !ok {
+		return statusPacket(id, sshfxp.StatusOpUnsupported, "handle is not readable")
+	}
+
+	buf := make([]byte, m.Len)
+	n, err := r.ReadAt(buf, int64(m.Offset))
+	if n == 0 && err != nil {
+		if err == io.EOF {
+			return statusPacket(id, sshfxp.StatusEOF, "EOF")
+		}
+		return errStatusPacket(id, err)
+	}
+
+	var pkt sshfxp.Packet
+	pkt.Encode(&sshfxp.Data{ID: id, Data: buf[:n]})
+	return pkt
+}
+
+func (srv *Server) handleWrite(id uint32, m *sshfxp.Write) sshfxp.Packet {
+	v, ok := srv.openHandles.get(m.Handle)
+	if !ok {
+		return statusPacket(id, sshfxp.StatusFailure, "invalid handle")
+	}
+
+	w, ok := v.(io.WriterAt)
+	if !ok {
+		return statusPacket(id, sshfxp.StatusOpUnsupported, "handle is not writable")
+	}
+
+	if _, err := w.WriteAt(m.Data, int64(m.Offset)); err != nil {
+		return errStatusPacket(id, err)
+	}
+
+	return statusPacket(id, sshfxp.StatusOK, "")
+}
+
+func (srv *Server) handleOpenDir(id uint32, m *sshfxp.OpenDir) sshfxp.Packet {
+	if srv.handlers.FileList == nil {
+		return statusPacket(id, sshfxp.StatusOpUnsupported, "listing not supported")
+	}
+
+	lister, err := srv.handlers.FileList.Filelist(&Request{Method: MethodOpenDir, Filepath: m.Path})
+	if err != nil {
+		return errStatusPacket(id, err)
+	}
+
+	handle := srv.openHandles.new(&listerCursor{lister: lister})
+	var pkt sshfxp.Packet
+	pkt.Encode(&sshfxp.Handle{ID: id, Handle: handle})
+	return pkt
+}
+
+// listerCursor tracks how far a READDIR handle has paged into its
+// ListerAt, since sshfxp READDIR takes no explicit offset.
+type listerCursor struct {
+	lister ListerAt
+	offset int64
+}
+
+func (srv *Server) handleReadDir(id uint32, m *sshfxp.ReadDir) sshfxp.Packet {
+	v, ok := srv.openHandles.get(m.Handle)
+	if !ok {
+		return statusPacket(id, sshfxp.StatusFailure, "invalid handle")
+	}
+
+	cur, ok := v.(*listerCursor)
+	if !ok {
+		return statusPacket(id, sshfxp.StatusOpUnsupported, "handle is not a directory")
+	}
+
+	entries := make([]os.FileInfo, 64)
+	n, err := cur.lister.ListAt(entries, cur.offset)
+	cur.offset += int64(n)
+
+	if n == 0 {
+		if err == io.EOF || err == nil {
+			return statusPacket(id, sshfxp.StatusEOF, "EOF")
+		}
+		return errStatusPacket(id, err)
+	}
+
+	var pkt sshfxp.Packet
+	pkt.Encode(&sshfxp.Name{ID: id, Entries: entries[:n]})
+	return pkt
+}
+
+// handleStat serves STAT, LSTAT and REALPATH, all of which resolve to a
+// single directory entry rather than a handle. It reuses FileList, asking
+// for a lister of path itself under the stat method and reading its entry
+// at offset 0, instead of requiring a separate lookup interface.
+func (srv *Server) handleStat(id uint32, method, path string) sshfxp.Packet {
+	if srv.handlers.FileList == nil {
+		return statusPacket(id, sshfxp.StatusOpUnsupported, "stat not supported")
+	}
+
+	lister, err := srv.handlers.FileList.Filelist(&Request{Method: method, Filepath: path})
+	if err != nil {
+		return errStatusPacket(id, err)
+	}
+
+	entries := make([]os.FileInfo, 1)
+	n, err := lister.ListAt(entries, 0)
+	if n == 0 {
+		if err == nil || err == io.EOF {
+			err = os.ErrNotExist
+		}
+		return errStatusPacket(id, err)
+	}
+
+	var pkt sshfxp.Packet
+
+	if method == MethodRealpath {
+		pkt.Encode(&sshfxp.Name{ID: id, Entries: entries[:1]})
+	} else {
+		pkt.Encode(&sshfxp.Attrs{ID: id, Attrs: attrFromFileInfo(entries[0])})
+	}
+
+	return pkt
+}
+
+// attrFromFileInfo does a best-effort translation of an os.FileInfo into
+// the subset of sshfxp.Attr a STAT/LSTAT reply needs.
+func attrFromFileInfo(info os.FileInfo) sshfxp.Attr {
+	return sshfxp.Attr{
+		Size:        uint64(info.Size()),
+		Permissions: uint32(info.Mode().Perm()),
+	}
+}
+
+func (srv *Server) handleCmd(id uint32, req *Request) sshfxp.Packet {
+	if srv.handlers.FileCmd == nil {
+		return statusPacket(id, sshfxp.StatusOpUnsupported, "command not supported")
+	}
+
+	if err := srv.handlers.FileCmd.Filecmd(req); err != nil {
+		return errStatusPacket(id, err)
+	}
+
+	return statusPacket(id, sshfxp.StatusOK, "")
+}
@@ -0,0 +1,134 @@
+package sftp
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"testing"
+)
+
+// newTestClientServer wires a Client to a Server backed by InMemHandler
+// over an in-process net.Pipe, and arranges for the Server to be cleaned
+// up when the test finishes.
+func newTestClientServer(t *testing.T, opts ...ClientOption) *Client {
+	t.Helper()
+
+	clientConn, serverConn := net.Pipe()
+
+	srv := NewServer(serverConn, InMemHandler())
+
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve() }()
+
+	cli := NewClient(clientConn, clientConn, opts...)
+	if cli == nil {
+		t.Fatal("handshake with in-memory server failed")
+	}
+
+	t.Cleanup(func() {
+		cli.Wait()
+		<-done
+	})
+
+	return cli
+}
+
+func TestServerOpenWriteReadRoundTrip(t *testing.T) {
+	cli := newTestClientServer(t)
+	ctx := context.Background()
+
+	want := []byte("hello from the in-memory backend")
+
+	wf, err := cli.OpenFile(ctx, "/greeting.txt", os.O_WRONLY|os.O_CREATE)
+	if err != nil {
+		t.Fatalf("OpenFile (write): %s", err)
+	}
+	if _, err := wf.WriteAtContext(ctx, want, 0); err != nil {
+		t.Fatalf("WriteAt: %s", err)
+	}
+	if err := wf.CloseContext(ctx); err != nil {
+		t.Fatalf("Close (write): %s", err)
+	}
+
+	rf, err := cli.Open(ctx, "/greeting.txt")
+	if err != nil {
+		t.Fatalf("Open (read): %s", err)
+	}
+	defer rf.CloseContext(ctx)
+
+	got := make([]byte, len(want))
+	n, err := rf.ReadAtContext(ctx, got, 0)
+	if err != nil && err != io.EOF {
+		t.Fatalf("ReadAt: %s", err)
+	}
+	if n != len(want) || string(got[:n]) != string(want) {
+		t.Fatalf("got %q (%d bytes), want %q", got[:n], n, want)
+	}
+}
+
+func TestServerOpenRDWRSharesOneHandle(t *testing.T) {
+	cli := newTestClientServer(t)
+	ctx := context.Background()
+
+	f, err := cli.OpenFile(ctx, "/rdwr.txt", os.O_RDWR|os.O_CREATE)
+	if err != nil {
+		t.Fatalf("OpenFile (rdwr): %s", err)
+	}
+	defer f.CloseContext(ctx)
+
+	want := []byte("round trip on one handle")
+	if _, err := f.WriteAtContext(ctx, want, 0); err != nil {
+		t.Fatalf("WriteAt on rdwr handle: %s", err)
+	}
+
+	got := make([]byte, len(want))
+	n, err := f.ReadAtContext(ctx, got, 0)
+	if err != nil && err != io.EOF {
+		t.Fatalf("ReadAt on rdwr handle: %s", err)
+	}
+	if n != len(want) || string(got[:n]) != string(want) {
+		t.Fatalf("got %q (%d bytes), want %q", got[:n], n, want)
+	}
+}
+
+func TestServerOpenDirAndReadDir(t *testing.T) {
+	cli := newTestClientServer(t)
+	ctx := context.Background()
+
+	for _, name := range []string{"/dir/a.txt", "/dir/b.txt"} {
+		f, err := cli.OpenFile(ctx, name, os.O_WRONLY|os.O_CREATE)
+		if err != nil {
+			t.Fatalf("OpenFile(%s): %s", name, err)
+		}
+		if err := f.CloseContext(ctx); err != nil {
+			t.Fatalf("Close(%s): %s", name, err)
+		}
+	}
+
+	handle, err := cli.OpenDir(ctx, "/dir")
+	if err != nil {
+		t.Fatalf("OpenDir: %s", err)
+	}
+	defer cli.CloseDir(ctx, handle)
+
+	var names []string
+	for {
+		infos, err := cli.ReadDir(ctx, handle)
+		for _, info := range infos {
+			if info != nil {
+				names = append(names, (*info).Name())
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadDir: %s", err)
+		}
+	}
+
+	if len(names) != 2 {
+		t.Fatalf("got %d entries, want 2: %v", len(names), names)
+	}
+}
@@ -0,0 +1,152 @@
+package sftp
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"sort"
+)
+
+// Walker walks a remote directory tree, lazily issuing OpenDir/ReadDir
+// calls as it descends. Modeled on kr/fs's Walker: create one with
+// Client.Walk, then call Step in a loop until it returns false.
+//
+// Since the client has no Stat/Lstat RPC yet, the root passed to Walk is
+// assumed to be a directory; Stat returns nil for it. Every other path
+// visited gets its os.FileInfo from the parent directory's ReadDir entry.
+type Walker struct {
+	cli *Client
+	ctx context.Context
+
+	stack   []*walkItem
+	current *walkItem
+	err     error
+}
+
+type walkItem struct {
+	path string
+	info os.FileInfo // nil for the root item
+}
+
+// Walk returns a Walker rooted at root. root itself is visited first.
+func (cli *Client) Walk(root string) *Walker {
+	return cli.WalkContext(context.Background(), root)
+}
+
+// WalkContext is like Walk, but aborts a pending OpenDir/ReadDir with
+// ctx.Err() if ctx is done first.
+func (cli *Client) WalkContext(ctx context.Context, root string) *Walker {
+	return &Walker{
+		cli:   cli,
+		ctx:   ctx,
+		stack: []*walkItem{{path: root}},
+	}
+}
+
+// Step advances the walker to the next file or directory in the tree,
+// depth-first. It returns false once the walk is exhausted. An error reading
+// a directory is reported by Err after the Step that visited it, which still
+// returns true; callers should check Err after every Step.
+func (w *Walker) Step() bool {
+	if len(w.stack) == 0 {
+		return false
+	}
+
+	w.current = w.stack[len(w.stack)-1]
+	w.stack = w.stack[:len(w.stack)-1]
+	w.err = nil
+
+	if w.current.info == nil || w.current.info.IsDir() {
+		entries, err := w.readDirInfos(w.current.path)
+		if err != nil {
+			w.err = err
+			return true
+		}
+
+		// Push in reverse name order so Step visits entries sorted by
+		// name, since stack pops from the end.
+		sort.Sort(sort.Reverse(byName(entries)))
+
+		for _, entry := range entries {
+			w.stack = append(w.stack, &walkItem{path: path.Join(w.current.path, entry.Name()), info: entry})
+		}
+	}
+
+	return true
+}
+
+type byName []os.FileInfo
+
+func (e byName) Len() int           { return len(e) }
+func (e byName) Less(i, j int) bool { return e[i].Name() < e[j].Name() }
+func (e byName) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+
+// Path returns the path of the most recent file or directory visited by
+// Step.
+func (w *Walker) Path() string {
+	return w.current.path
+}
+
+// Stat returns the os.FileInfo of the most recent file or directory
+// visited by Step, or nil for the walk's root.
+func (w *Walker) Stat() os.FileInfo {
+	return w.current.info
+}
+
+// Err returns the error, if any, encountered visiting the path returned by
+// the most recent call to Step.
+func (w *Walker) Err() error {
+	return w.err
+}
+
+// SkipDir causes the children of the directory most recently visited by
+// Step to be skipped. It is a no-op if that item is not a directory.
+func (w *Walker) SkipDir() {
+	if w.current.info != nil && !w.current.info.IsDir() {
+		return
+	}
+
+	// Children were pushed as path.Join(w.current.path, name), which
+	// collapses to a single leading slash for the root ("/" + "name" ->
+	// "/name"); naively appending "/" to the root path itself would
+	// produce "//" and never match.
+	prefix := w.current.path
+	if prefix != "/" {
+		prefix += "/"
+	}
+
+	kept := w.stack[:0]
+	for _, item := range w.stack {
+		if len(item.path) <= len(prefix) || item.path[:len(prefix)] != prefix {
+			kept = append(kept, item)
+		}
+	}
+	w.stack = kept
+}
+
+func (w *Walker) readDirInfos(dir string) ([]os.FileInfo, error) {
+	handle, err := w.cli.OpenDir(w.ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	defer w.cli.CloseDir(w.ctx, handle)
+
+	var entries []os.FileInfo
+
+	for {
+		infos, err := w.cli.ReadDir(w.ctx, handle)
+		for _, info := range infos {
+			if info != nil {
+				entries = append(entries, *info)
+			}
+		}
+
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, err
+		}
+	}
+}